@@ -0,0 +1,594 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package defaultserviceplan contains an admission controller that defaults
+// a ServiceInstance's ServicePlan when it can pick exactly one: the only
+// plan of the class, the plan matched by the instance's DefaultPlanSelector,
+// or the plan labeled DefaultServicePlanLabelKey=true.
+package defaultserviceplan
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/kubernetes-incubator/service-catalog/pkg/apis/servicecatalog"
+	scadmission "github.com/kubernetes-incubator/service-catalog/pkg/apiserver/admission"
+	internalclientset "github.com/kubernetes-incubator/service-catalog/pkg/client/clientset_generated/internalclientset"
+	informers "github.com/kubernetes-incubator/service-catalog/pkg/client/informers_generated/internalversion"
+)
+
+const (
+	// PluginName is the name of this admission controller plugin.
+	PluginName = "DefaultServicePlan"
+
+	byExternalNameIndex           = "externalName"
+	byExternalIDIndex             = "externalID"
+	byClusterServiceClassRefIndex = "clusterServiceClassRef"
+	byServiceClassRefIndex        = "serviceClassRef"
+
+	// DefaultServicePlanLabelKey is the label an operator sets on exactly
+	// one ClusterServicePlan/ServicePlan per class to mark it as the plan
+	// to pick when a ServiceInstance omits PlanName and does not supply its
+	// own DefaultPlanSelector.
+	DefaultServicePlanLabelKey = "servicecatalog.k8s.io/default-plan"
+
+	// DefaultedServicePlanEventReason is the reason recorded on the Normal
+	// event emitted against a ServiceInstance whenever this plugin fills in
+	// its PlanReference.
+	DefaultedServicePlanEventReason = "DefaultedServicePlan"
+)
+
+// Register registers a plugin.
+func Register(plugins *admission.Plugins) {
+	plugins.Register(PluginName, func(config io.Reader) (admission.Interface, error) {
+		return NewDefaultClusterServicePlan()
+	})
+}
+
+// defaultServicePlan is an implementation of admission.Interface.
+// It looks at all incoming ServiceInstances that do not specify a plan and
+// fills in a default plan if possible, based on the rest of the fields in
+// the PlanReference. It is backed entirely by the informer caches set up
+// by its SharedInformerFactory, so Admit never makes an apiserver round
+// trip: defaulting a plan is a map lookup against the relevant index, not
+// a List of every plan in the catalog.
+type defaultServicePlan struct {
+	*admission.Handler
+	client   internalclientset.Interface
+	recorder record.EventRecorder
+
+	clusterServiceClassInformer cache.SharedIndexInformer
+	clusterServicePlanInformer  cache.SharedIndexInformer
+	serviceClassInformer        cache.SharedIndexInformer
+	servicePlanInformer         cache.SharedIndexInformer
+
+	// stopCh bounds how long ValidateInitialization waits for the informer
+	// caches above to sync. It defaults to wait.NeverStop; tests that want
+	// to exercise the sync-timeout path may substitute their own channel.
+	stopCh <-chan struct{}
+}
+
+var _ admission.MutationInterface = &defaultServicePlan{}
+var _ = scadmission.WantsInternalServiceCatalogClientSet(&defaultServicePlan{})
+var _ = scadmission.WantsInternalServiceCatalogInformerFactory(&defaultServicePlan{})
+var _ = scadmission.WantsInternalServiceCatalogEventRecorder(&defaultServicePlan{})
+
+// Admit implements the admission.Interface interface.
+func (d *defaultServicePlan) Admit(a admission.Attributes) error {
+	if a.GetResource().GroupResource() != servicecatalog.Resource("serviceinstances") {
+		return nil
+	}
+	if len(a.GetSubresource()) != 0 {
+		return nil
+	}
+	instance, ok := a.GetObject().(*servicecatalog.ServiceInstance)
+	if !ok {
+		return apierrors.NewBadRequest("Resource was marked with kind ServiceInstance but was unable to be converted")
+	}
+
+	// If the plan is already fully specified, let it through untouched. Note
+	// that this does not validate that the referenced plan is still active;
+	// that is the job of the ServiceInstance strategy/controller, not this
+	// defaulting admission plugin.
+	if instance.Spec.ClusterServicePlanExternalName != "" ||
+		instance.Spec.ClusterServicePlanExternalID != "" ||
+		instance.Spec.ClusterServicePlanName != "" ||
+		instance.Spec.ServicePlanExternalName != "" ||
+		instance.Spec.ServicePlanExternalID != "" ||
+		instance.Spec.ServicePlanName != "" {
+		return nil
+	}
+
+	if instance.Spec.ServiceClassExternalName != "" ||
+		instance.Spec.ServiceClassExternalID != "" ||
+		instance.Spec.ServiceClassName != "" {
+		return d.admitNamespaced(a, instance)
+	}
+
+	return d.admitCluster(a, instance)
+}
+
+// admitCluster defaults the plan of an instance that references a
+// cluster-scoped ClusterServiceClass.
+func (d *defaultServicePlan) admitCluster(a admission.Attributes, instance *servicecatalog.ServiceInstance) error {
+	var sc *servicecatalog.ClusterServiceClass
+	var err error
+
+	switch {
+	case instance.Spec.ClusterServiceClassName != "":
+		obj, exists, getErr := d.clusterServiceClassInformer.GetIndexer().GetByKey(instance.Spec.ClusterServiceClassName)
+		if getErr != nil {
+			return admission.NewForbidden(a, getErr)
+		}
+		if !exists {
+			return admission.NewForbidden(a, fmt.Errorf("ClusterServiceClass (K8S: %q) does not exist, can not figure out the default Service Plan", instance.Spec.ClusterServiceClassName))
+		}
+		sc = obj.(*servicecatalog.ClusterServiceClass)
+		if isClusterServiceClassRemoved(sc) {
+			return admission.NewForbidden(a, fmt.Errorf("ClusterServiceClass (K8S: %q) has been removed from the broker catalog and can not be used to default a Service Plan", sc.Name))
+		}
+	case instance.Spec.ClusterServiceClassExternalName != "":
+		sc, err = d.getClusterServiceClassByIndex(byExternalNameIndex, instance.Spec.ClusterServiceClassExternalName)
+	case instance.Spec.ClusterServiceClassExternalID != "":
+		sc, err = d.getClusterServiceClassByIndex(byExternalIDIndex, instance.Spec.ClusterServiceClassExternalID)
+	default:
+		// no class reference at all, nothing for us to default.
+		return nil
+	}
+	if err != nil {
+		return admission.NewForbidden(a, err)
+	}
+
+	plans, err := d.getClusterServicePlansByClassName(sc.Name)
+	if err != nil {
+		return admission.NewForbidden(a, err)
+	}
+
+	plan, err := resolveClusterServicePlan(sc, plans, instance.Spec.DefaultPlanSelector)
+	if err != nil {
+		return admission.NewForbidden(a, err)
+	}
+
+	p := *plan
+	switch {
+	case instance.Spec.ClusterServiceClassName != "":
+		instance.Spec.ClusterServicePlanName = p.Name
+	case instance.Spec.ClusterServiceClassExternalName != "":
+		instance.Spec.ClusterServicePlanExternalName = p.Spec.ExternalName
+	case instance.Spec.ClusterServiceClassExternalID != "":
+		instance.Spec.ClusterServicePlanExternalID = p.Spec.ExternalID
+	}
+	d.recorder.Eventf(instance, corev1.EventTypeNormal, DefaultedServicePlanEventReason,
+		"Defaulted ClusterServicePlan %s for ClusterServiceClass %s", prettyClusterServicePlanName(p), prettyClusterServiceClassName(*sc))
+	return nil
+}
+
+// admitNamespaced defaults the plan of an instance that references a
+// namespace-scoped ServiceClass.
+func (d *defaultServicePlan) admitNamespaced(a admission.Attributes, instance *servicecatalog.ServiceInstance) error {
+	var sc *servicecatalog.ServiceClass
+	var err error
+
+	ns := instance.Namespace
+
+	switch {
+	case instance.Spec.ServiceClassName != "":
+		obj, exists, getErr := d.serviceClassInformer.GetIndexer().GetByKey(ns + "/" + instance.Spec.ServiceClassName)
+		if getErr != nil {
+			return admission.NewForbidden(a, getErr)
+		}
+		if !exists {
+			return admission.NewForbidden(a, fmt.Errorf("ServiceClass (K8S: %q) does not exist, can not figure out the default Service Plan", instance.Spec.ServiceClassName))
+		}
+		sc = obj.(*servicecatalog.ServiceClass)
+		if isServiceClassRemoved(sc) {
+			return admission.NewForbidden(a, fmt.Errorf("ServiceClass (K8S: %q) has been removed from the broker catalog and can not be used to default a Service Plan", sc.Name))
+		}
+	case instance.Spec.ServiceClassExternalName != "":
+		sc, err = d.getServiceClassByIndex(ns, byExternalNameIndex, instance.Spec.ServiceClassExternalName)
+	case instance.Spec.ServiceClassExternalID != "":
+		sc, err = d.getServiceClassByIndex(ns, byExternalIDIndex, instance.Spec.ServiceClassExternalID)
+	default:
+		return nil
+	}
+	if err != nil {
+		return admission.NewForbidden(a, err)
+	}
+
+	plans, err := d.getServicePlansByClassName(ns, sc.Name)
+	if err != nil {
+		return admission.NewForbidden(a, err)
+	}
+
+	plan, err := resolveServicePlan(sc, plans, instance.Spec.DefaultPlanSelector)
+	if err != nil {
+		return admission.NewForbidden(a, err)
+	}
+
+	p := *plan
+	switch {
+	case instance.Spec.ServiceClassName != "":
+		instance.Spec.ServicePlanName = p.Name
+	case instance.Spec.ServiceClassExternalName != "":
+		instance.Spec.ServicePlanExternalName = p.Spec.ExternalName
+	case instance.Spec.ServiceClassExternalID != "":
+		instance.Spec.ServicePlanExternalID = p.Spec.ExternalID
+	}
+	d.recorder.Eventf(instance, corev1.EventTypeNormal, DefaultedServicePlanEventReason,
+		"Defaulted ServicePlan %s for ServiceClass %s", prettyServicePlanName(p), prettyServiceClassName(*sc))
+	return nil
+}
+
+// getClusterServiceClassByIndex returns the non-removed ClusterServiceClass
+// keyed under index/value in the ClusterServiceClass informer's cache.
+func (d *defaultServicePlan) getClusterServiceClassByIndex(index, value string) (*servicecatalog.ClusterServiceClass, error) {
+	objs, err := d.clusterServiceClassInformer.GetIndexer().ByIndex(index, value)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		sc := obj.(*servicecatalog.ClusterServiceClass)
+		if isClusterServiceClassRemoved(sc) {
+			continue
+		}
+		return sc, nil
+	}
+	return nil, fmt.Errorf("ClusterServiceClass %q does not exist, can not figure out the default Service Plan", value)
+}
+
+// getServiceClassByIndex returns the non-removed ServiceClass, scoped to
+// namespace ns, keyed under index/value in the ServiceClass informer's
+// cache.
+func (d *defaultServicePlan) getServiceClassByIndex(ns, index, value string) (*servicecatalog.ServiceClass, error) {
+	objs, err := d.serviceClassInformer.GetIndexer().ByIndex(index, value)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		sc := obj.(*servicecatalog.ServiceClass)
+		if sc.Namespace != ns {
+			continue
+		}
+		if isServiceClassRemoved(sc) {
+			continue
+		}
+		return sc, nil
+	}
+	return nil, fmt.Errorf("ServiceClass %q does not exist, can not figure out the default Service Plan", value)
+}
+
+// getClusterServicePlansByClassName returns every non-removed
+// ClusterServicePlan that belongs to the ClusterServiceClass named
+// className, using the clusterServiceClassRef.name index so this is an
+// O(plans-per-class) cache lookup rather than a List of the whole catalog.
+func (d *defaultServicePlan) getClusterServicePlansByClassName(className string) ([]servicecatalog.ClusterServicePlan, error) {
+	objs, err := d.clusterServicePlanInformer.GetIndexer().ByIndex(byClusterServiceClassRefIndex, className)
+	if err != nil {
+		return nil, err
+	}
+	var plans []servicecatalog.ClusterServicePlan
+	for _, obj := range objs {
+		p := obj.(*servicecatalog.ClusterServicePlan)
+		if p.Status.RemovedFromBrokerCatalog || p.DeletionTimestamp != nil {
+			continue
+		}
+		plans = append(plans, *p)
+	}
+	return plans, nil
+}
+
+// getServicePlansByClassName returns every non-removed ServicePlan in
+// namespace ns that belongs to the ServiceClass named className, using the
+// serviceClassRef.name index.
+func (d *defaultServicePlan) getServicePlansByClassName(ns, className string) ([]servicecatalog.ServicePlan, error) {
+	objs, err := d.servicePlanInformer.GetIndexer().ByIndex(byServiceClassRefIndex, className)
+	if err != nil {
+		return nil, err
+	}
+	var plans []servicecatalog.ServicePlan
+	for _, obj := range objs {
+		p := obj.(*servicecatalog.ServicePlan)
+		if p.Namespace != ns {
+			continue
+		}
+		if p.Status.RemovedFromBrokerCatalog || p.DeletionTimestamp != nil {
+			continue
+		}
+		plans = append(plans, *p)
+	}
+	return plans, nil
+}
+
+// resolveClusterServicePlan picks the single plan that defaulting should use
+// out of the non-removed plans belonging to sc. When selector (the
+// ServiceInstance's DefaultPlanSelector) is set, it is applied to every
+// candidate, even when there is only one. Otherwise a sole candidate is
+// used as is, and among several the plan carrying the
+// DefaultServicePlanLabelKey label is used. It is an error for either
+// mechanism to match zero or more than one plan.
+func resolveClusterServicePlan(sc *servicecatalog.ClusterServiceClass, plans []servicecatalog.ClusterServicePlan, selector *metav1.LabelSelector) (*servicecatalog.ClusterServicePlan, error) {
+	if len(plans) == 0 {
+		return nil, fmt.Errorf("no ClusterServicePlans found at all for ClusterServiceClass %q", sc.Name)
+	}
+	if len(plans) == 1 && selector == nil {
+		return &plans[0], nil
+	}
+
+	if selector != nil {
+		sel, err := metav1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return nil, fmt.Errorf("ClusterServiceClass %q: invalid DefaultPlanSelector: %v", sc.Name, err)
+		}
+		var matching []servicecatalog.ClusterServicePlan
+		for _, p := range plans {
+			if sel.Matches(labels.Set(p.Labels)) {
+				matching = append(matching, p)
+			}
+		}
+		switch len(matching) {
+		case 1:
+			return &matching[0], nil
+		case 0:
+			return nil, fmt.Errorf("ClusterServiceClass %q: DefaultPlanSelector matched no plan, candidates were: %s", sc.Name, formatClusterServicePlans(plans))
+		default:
+			return nil, fmt.Errorf("ClusterServiceClass %q: DefaultPlanSelector matched more than one plan, candidates were: %s", sc.Name, formatClusterServicePlans(matching))
+		}
+	}
+
+	var labeledDefault []servicecatalog.ClusterServicePlan
+	for _, p := range plans {
+		if p.Labels[DefaultServicePlanLabelKey] == "true" {
+			labeledDefault = append(labeledDefault, p)
+		}
+	}
+	switch len(labeledDefault) {
+	case 1:
+		return &labeledDefault[0], nil
+	case 0:
+		return nil, fmt.Errorf("ClusterServiceClass %q has more than one plan, PlanName must be specified, candidates were: %s", sc.Name, formatClusterServicePlans(plans))
+	default:
+		return nil, fmt.Errorf("ClusterServiceClass %q has more than one plan labeled %q=true, PlanName must be specified, candidates were: %s", sc.Name, DefaultServicePlanLabelKey, formatClusterServicePlans(labeledDefault))
+	}
+}
+
+// resolveServicePlan is the namespace-scoped equivalent of
+// resolveClusterServicePlan.
+func resolveServicePlan(sc *servicecatalog.ServiceClass, plans []servicecatalog.ServicePlan, selector *metav1.LabelSelector) (*servicecatalog.ServicePlan, error) {
+	if len(plans) == 0 {
+		return nil, fmt.Errorf("no ServicePlans found at all for ServiceClass %q", sc.Name)
+	}
+	if len(plans) == 1 && selector == nil {
+		return &plans[0], nil
+	}
+
+	if selector != nil {
+		sel, err := metav1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return nil, fmt.Errorf("ServiceClass %q: invalid DefaultPlanSelector: %v", sc.Name, err)
+		}
+		var matching []servicecatalog.ServicePlan
+		for _, p := range plans {
+			if sel.Matches(labels.Set(p.Labels)) {
+				matching = append(matching, p)
+			}
+		}
+		switch len(matching) {
+		case 1:
+			return &matching[0], nil
+		case 0:
+			return nil, fmt.Errorf("ServiceClass %q: DefaultPlanSelector matched no plan, candidates were: %s", sc.Name, formatServicePlans(plans))
+		default:
+			return nil, fmt.Errorf("ServiceClass %q: DefaultPlanSelector matched more than one plan, candidates were: %s", sc.Name, formatServicePlans(matching))
+		}
+	}
+
+	var labeledDefault []servicecatalog.ServicePlan
+	for _, p := range plans {
+		if p.Labels[DefaultServicePlanLabelKey] == "true" {
+			labeledDefault = append(labeledDefault, p)
+		}
+	}
+	switch len(labeledDefault) {
+	case 1:
+		return &labeledDefault[0], nil
+	case 0:
+		return nil, fmt.Errorf("ServiceClass %q has more than one plan, PlanName must be specified, candidates were: %s", sc.Name, formatServicePlans(plans))
+	default:
+		return nil, fmt.Errorf("ServiceClass %q has more than one plan labeled %q=true, PlanName must be specified, candidates were: %s", sc.Name, DefaultServicePlanLabelKey, formatServicePlans(labeledDefault))
+	}
+}
+
+// prettyClusterServicePlanName formats a plan as "<external-name>
+// (<k8s-name>)" so admission error messages are legible to the end user
+// (who knows the external name) and to an operator debugging via kubectl
+// (who knows the k8s name).
+func prettyClusterServicePlanName(p servicecatalog.ClusterServicePlan) string {
+	return fmt.Sprintf("%s (%s)", p.Spec.ExternalName, p.Name)
+}
+
+func prettyServicePlanName(p servicecatalog.ServicePlan) string {
+	return fmt.Sprintf("%s (%s)", p.Spec.ExternalName, p.Name)
+}
+
+// prettyClusterServiceClassName is the ClusterServiceClass equivalent of
+// prettyClusterServicePlanName.
+func prettyClusterServiceClassName(sc servicecatalog.ClusterServiceClass) string {
+	return fmt.Sprintf("%s (%s)", sc.Spec.ExternalName, sc.Name)
+}
+
+// prettyServiceClassName is the ServiceClass equivalent of
+// prettyClusterServicePlanName.
+func prettyServiceClassName(sc servicecatalog.ServiceClass) string {
+	return fmt.Sprintf("%s (%s)", sc.Spec.ExternalName, sc.Name)
+}
+
+func formatClusterServicePlans(plans []servicecatalog.ClusterServicePlan) string {
+	names := make([]string, 0, len(plans))
+	for _, p := range plans {
+		names = append(names, prettyClusterServicePlanName(p))
+	}
+	return strings.Join(names, ", ")
+}
+
+func formatServicePlans(plans []servicecatalog.ServicePlan) string {
+	names := make([]string, 0, len(plans))
+	for _, p := range plans {
+		names = append(names, prettyServicePlanName(p))
+	}
+	return strings.Join(names, ", ")
+}
+
+// isClusterServiceClassRemoved returns whether the given ClusterServiceClass
+// has been removed from its broker's catalog, or is in the process of being
+// deleted, and therefore must not be used to default a Service Plan.
+func isClusterServiceClassRemoved(sc *servicecatalog.ClusterServiceClass) bool {
+	return sc.Status.RemovedFromBrokerCatalog || sc.DeletionTimestamp != nil
+}
+
+// isServiceClassRemoved returns whether the given ServiceClass has been
+// removed from its broker's catalog, or is in the process of being deleted,
+// and therefore must not be used to default a Service Plan.
+func isServiceClassRemoved(sc *servicecatalog.ServiceClass) bool {
+	return sc.Status.RemovedFromBrokerCatalog || sc.DeletionTimestamp != nil
+}
+
+func indexByExternalName(obj interface{}) ([]string, error) {
+	switch t := obj.(type) {
+	case *servicecatalog.ClusterServiceClass:
+		return []string{t.Spec.ExternalName}, nil
+	case *servicecatalog.ServiceClass:
+		return []string{t.Spec.ExternalName}, nil
+	default:
+		return nil, fmt.Errorf("expected a ClusterServiceClass or ServiceClass, got %T", obj)
+	}
+}
+
+func indexByExternalID(obj interface{}) ([]string, error) {
+	switch t := obj.(type) {
+	case *servicecatalog.ClusterServiceClass:
+		return []string{t.Spec.ExternalID}, nil
+	case *servicecatalog.ServiceClass:
+		return []string{t.Spec.ExternalID}, nil
+	default:
+		return nil, fmt.Errorf("expected a ClusterServiceClass or ServiceClass, got %T", obj)
+	}
+}
+
+func indexByClusterServiceClassRef(obj interface{}) ([]string, error) {
+	p, ok := obj.(*servicecatalog.ClusterServicePlan)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterServicePlan, got %T", obj)
+	}
+	return []string{p.Spec.ClusterServiceClassRef.Name}, nil
+}
+
+func indexByServiceClassRef(obj interface{}) ([]string, error) {
+	p, ok := obj.(*servicecatalog.ServicePlan)
+	if !ok {
+		return nil, fmt.Errorf("expected a ServicePlan, got %T", obj)
+	}
+	return []string{p.Spec.ServiceClassRef.Name}, nil
+}
+
+// NewDefaultClusterServicePlan creates a new admission control handler that
+// fills in a default Service Plan for ServiceInstances that do not specify
+// one, provided exactly one non-removed plan of the referenced Service Class
+// can be picked (see resolveClusterServicePlan).
+func NewDefaultClusterServicePlan() (admission.Interface, error) {
+	return &defaultServicePlan{
+		Handler: admission.NewHandler(admission.Create),
+		stopCh:  wait.NeverStop,
+	}, nil
+}
+
+// SetInternalServiceCatalogClientSet implements the
+// WantsInternalServiceCatalogClientSet interface.
+func (d *defaultServicePlan) SetInternalServiceCatalogClientSet(client internalclientset.Interface) {
+	d.client = client
+}
+
+// SetInternalServiceCatalogEventRecorder implements the
+// WantsInternalServiceCatalogEventRecorder interface.
+func (d *defaultServicePlan) SetInternalServiceCatalogEventRecorder(recorder record.EventRecorder) {
+	d.recorder = recorder
+}
+
+// SetInternalServiceCatalogInformerFactory implements the
+// WantsInternalServiceCatalogInformerFactory interface. It wires up the
+// indexes Admit relies on to resolve a class/plan without ever calling out
+// to the apiserver.
+func (d *defaultServicePlan) SetInternalServiceCatalogInformerFactory(f informers.SharedInformerFactory) {
+	classInformer := f.Servicecatalog().InternalVersion().ClusterServiceClasses().Informer()
+	classInformer.AddIndexers(cache.Indexers{
+		byExternalNameIndex: indexByExternalName,
+		byExternalIDIndex:   indexByExternalID,
+	})
+	d.clusterServiceClassInformer = classInformer
+
+	planInformer := f.Servicecatalog().InternalVersion().ClusterServicePlans().Informer()
+	planInformer.AddIndexers(cache.Indexers{
+		byClusterServiceClassRefIndex: indexByClusterServiceClassRef,
+	})
+	d.clusterServicePlanInformer = planInformer
+
+	nsClassInformer := f.Servicecatalog().InternalVersion().ServiceClasses().Informer()
+	nsClassInformer.AddIndexers(cache.Indexers{
+		byExternalNameIndex: indexByExternalName,
+		byExternalIDIndex:   indexByExternalID,
+	})
+	d.serviceClassInformer = nsClassInformer
+
+	nsPlanInformer := f.Servicecatalog().InternalVersion().ServicePlans().Informer()
+	nsPlanInformer.AddIndexers(cache.Indexers{
+		byServiceClassRefIndex: indexByServiceClassRef,
+	})
+	d.servicePlanInformer = nsPlanInformer
+}
+
+// ValidateInitialization implements the InitializationValidator interface.
+// It blocks until the informer caches backing Admit have synced, so that
+// the plugin never serves a request against a half-populated cache.
+func (d *defaultServicePlan) ValidateInitialization() error {
+	if d.client == nil {
+		return fmt.Errorf("missing client")
+	}
+	if d.clusterServiceClassInformer == nil || d.clusterServicePlanInformer == nil ||
+		d.serviceClassInformer == nil || d.servicePlanInformer == nil {
+		return fmt.Errorf("missing service catalog informers")
+	}
+	if d.recorder == nil {
+		return fmt.Errorf("missing event recorder")
+	}
+	if !cache.WaitForCacheSync(d.stopCh,
+		d.clusterServiceClassInformer.HasSynced,
+		d.clusterServicePlanInformer.HasSynced,
+		d.serviceClassInformer.HasSynced,
+		d.servicePlanInformer.HasSynced) {
+		return fmt.Errorf("%s: caches failed to sync", PluginName)
+	}
+	return nil
+}
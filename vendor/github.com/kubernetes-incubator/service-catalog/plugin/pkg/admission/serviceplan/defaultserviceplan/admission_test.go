@@ -17,122 +17,66 @@ limitations under the License.
 package defaultserviceplan
 
 import (
-	"fmt"
 	"strings"
 	"testing"
 	"time"
 
-	"github.com/golang/glog"
-
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apiserver/pkg/admission"
-	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/kubernetes-incubator/service-catalog/pkg/apis/servicecatalog"
 	scadmission "github.com/kubernetes-incubator/service-catalog/pkg/apiserver/admission"
-	"github.com/kubernetes-incubator/service-catalog/pkg/client/clientset_generated/internalclientset"
 	"github.com/kubernetes-incubator/service-catalog/pkg/client/clientset_generated/internalclientset/fake"
 	informers "github.com/kubernetes-incubator/service-catalog/pkg/client/informers_generated/internalversion"
 )
 
-// newHandlerForTest returns a configured handler for testing.
-func newHandlerForTest(internalClient internalclientset.Interface) (admission.Interface, informers.SharedInformerFactory, error) {
-	f := informers.NewSharedInformerFactory(internalClient, 5*time.Minute)
+// newHandlerForTest returns a configured handler for testing, with its
+// informer caches already started and synced (they are empty until the
+// caller seeds them via the helpers below), and a FakeRecorder the caller
+// can inspect to assert on emitted events.
+func newHandlerForTest() (admission.Interface, informers.SharedInformerFactory, *record.FakeRecorder, error) {
+	fakeClient := &fake.Clientset{}
+	f := informers.NewSharedInformerFactory(fakeClient, 5*time.Minute)
+	fakeRecorder := record.NewFakeRecorder(10)
 	handler, err := NewDefaultClusterServicePlan()
 	if err != nil {
-		return nil, f, err
+		return nil, f, fakeRecorder, err
 	}
-	pluginInitializer := scadmission.NewPluginInitializer(internalClient, f, nil, nil)
+	pluginInitializer := scadmission.NewPluginInitializer(fakeClient, f, nil, fakeRecorder)
 	pluginInitializer.Initialize(handler)
+	f.Start(wait.NeverStop)
 	err = admission.ValidateInitialization(handler)
-	return handler, f, err
+	return handler, f, fakeRecorder, err
 }
 
-// newFakeServiceCatalogClientForTest creates a fake clientset that returns a
-// ClusterServiceClassList with the given ClusterServiceClass as the single list item
-// and list of ClusterServicePlan objects.
-// If classFilter is provided (as in not ""), then only sps with the
-// Spec.ClusterServiceClassRef.Name equaling that string will be added to the list.
-func newFakeServiceCatalogClientForTest(sc *servicecatalog.ClusterServiceClass, sps []*servicecatalog.ClusterServicePlan, classFilter string) *fake.Clientset {
-	fakeClient := &fake.Clientset{}
-
-	// react to the given service class list and to gets
-	fakeClient.AddReactor("get", "clusterserviceclasses", func(action core.Action) (bool, runtime.Object, error) {
-		if sc != nil {
-			return true, sc, nil
-		}
-		return true, nil, apierrors.NewNotFound(schema.GroupResource{}, "")
-	})
-
-	scList := &servicecatalog.ClusterServiceClassList{
-		ListMeta: metav1.ListMeta{
-			ResourceVersion: "1",
-		}}
+// addClusterServiceClassAndPlans seeds the given informer factory's
+// ClusterServiceClass/ClusterServicePlan indexers, as a controller would
+// once it observed them via the informers' watch.
+func addClusterServiceClassAndPlans(f informers.SharedInformerFactory, sc *servicecatalog.ClusterServiceClass, sps []*servicecatalog.ClusterServicePlan) {
+	classIndexer := f.Servicecatalog().InternalVersion().ClusterServiceClasses().Informer().GetIndexer()
 	if sc != nil {
-		scList.Items = append(scList.Items, *sc)
+		classIndexer.Add(sc)
 	}
-	fakeClient.AddReactor("list", "clusterserviceclasses", func(action core.Action) (bool, runtime.Object, error) {
-		return true, scList, nil
-	})
-
-	// react to the given plans
-	spList := &servicecatalog.ClusterServicePlanList{
-		ListMeta: metav1.ListMeta{
-			ResourceVersion: "1",
-		}}
+	planIndexer := f.Servicecatalog().InternalVersion().ClusterServicePlans().Informer().GetIndexer()
 	for _, sp := range sps {
-		if classFilter == "" || classFilter == sp.Spec.ClusterServiceClassRef.Name {
-			spList.Items = append(spList.Items, *sp)
-		}
+		planIndexer.Add(sp)
 	}
-	fakeClient.AddReactor("list", "clusterserviceplans", func(action core.Action) (bool, runtime.Object, error) {
-		return true, spList, nil
-	})
-
-	return fakeClient
 }
 
-func newFakeServiceCatalogClientForNamespacedTest(sc *servicecatalog.ServiceClass, sps []*servicecatalog.ServicePlan, classFilter string) *fake.Clientset {
-	fakeClient := &fake.Clientset{}
-
-	// react to the given service class list and to gets
-	fakeClient.AddReactor("get", "serviceclasses", func(action core.Action) (bool, runtime.Object, error) {
-		if sc != nil {
-			return true, sc, nil
-		}
-		return true, nil, apierrors.NewNotFound(schema.GroupResource{}, "")
-	})
-
-	scList := &servicecatalog.ServiceClassList{
-		ListMeta: metav1.ListMeta{
-			ResourceVersion: "1",
-		}}
+// addServiceClassAndPlans is the namespace-scoped equivalent of
+// addClusterServiceClassAndPlans.
+func addServiceClassAndPlans(f informers.SharedInformerFactory, sc *servicecatalog.ServiceClass, sps []*servicecatalog.ServicePlan) {
+	classIndexer := f.Servicecatalog().InternalVersion().ServiceClasses().Informer().GetIndexer()
 	if sc != nil {
-		scList.Items = append(scList.Items, *sc)
+		classIndexer.Add(sc)
 	}
-	fakeClient.AddReactor("list", "serviceclasses", func(action core.Action) (bool, runtime.Object, error) {
-		return true, scList, nil
-	})
-
-	// react to the given plans
-	spList := &servicecatalog.ServicePlanList{
-		ListMeta: metav1.ListMeta{
-			ResourceVersion: "1",
-		}}
+	planIndexer := f.Servicecatalog().InternalVersion().ServicePlans().Informer().GetIndexer()
 	for _, sp := range sps {
-		if classFilter == "" || classFilter == sp.Spec.ServiceClassRef.Name {
-			spList.Items = append(spList.Items, *sp)
-		}
+		planIndexer.Add(sp)
 	}
-	fakeClient.AddReactor("list", "serviceplans", func(action core.Action) (bool, runtime.Object, error) {
-		return true, spList, nil
-	})
-
-	return fakeClient
 }
 
 // newServiceInstance returns a new instance for the specified namespace.
@@ -162,7 +106,8 @@ func newClusterServiceClass(id string, name string) *servicecatalog.ClusterServi
 func newServiceClass(id string, name string) *servicecatalog.ServiceClass {
 	sc := &servicecatalog.ServiceClass{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: id,
+			Name:      id,
+			Namespace: "dummy",
 		},
 		Spec: servicecatalog.ServiceClassSpec{
 			CommonServiceClassSpec: servicecatalog.CommonServiceClassSpec{
@@ -221,7 +166,7 @@ func newClusterServicePlans(count uint, useDifferentClasses bool) []*servicecata
 func newServicePlans(count uint, useDifferentClasses bool) []*servicecatalog.ServicePlan {
 	classname := "test-serviceclass"
 	sp1 := &servicecatalog.ServicePlan{
-		ObjectMeta: metav1.ObjectMeta{Name: "bar-id"},
+		ObjectMeta: metav1.ObjectMeta{Name: "bar-id", Namespace: "dummy"},
 		Spec: servicecatalog.ServicePlanSpec{
 			CommonServicePlanSpec: servicecatalog.CommonServicePlanSpec{
 				ExternalName: "bar",
@@ -236,7 +181,7 @@ func newServicePlans(count uint, useDifferentClasses bool) []*servicecatalog.Ser
 		classname = "different-serviceclass"
 	}
 	sp2 := &servicecatalog.ServicePlan{
-		ObjectMeta: metav1.ObjectMeta{Name: "baz-id"},
+		ObjectMeta: metav1.ObjectMeta{Name: "baz-id", Namespace: "dummy"},
 		Spec: servicecatalog.ServicePlanSpec{
 			CommonServicePlanSpec: servicecatalog.CommonServicePlanSpec{
 				ExternalName: "baz",
@@ -260,29 +205,30 @@ func newServicePlans(count uint, useDifferentClasses bool) []*servicecatalog.Ser
 	return []*servicecatalog.ServicePlan{}
 }
 
-func TestWithListFailure(t *testing.T) {
+// TestValidateInitializationFailsWhenCachesDoNotSync checks that the plugin
+// refuses to serve admission requests until its informer caches have
+// synced, rather than racing ahead against an empty/partial cache.
+func TestValidateInitializationFailsWhenCachesDoNotSync(t *testing.T) {
 	fakeClient := &fake.Clientset{}
-	fakeClient.AddReactor("list", "clusterserviceclasses", func(action core.Action) (bool, runtime.Object, error) {
-		return true, nil, fmt.Errorf("simulated test failure")
-	})
-	handler, informerFactory, err := newHandlerForTest(fakeClient)
+	f := informers.NewSharedInformerFactory(fakeClient, 5*time.Minute)
+	handler, err := NewDefaultClusterServicePlan()
 	if err != nil {
-		t.Errorf("unexpected error initializing handler: %v", err)
+		t.Fatalf("unexpected error constructing handler: %v", err)
 	}
-	informerFactory.Start(wait.NeverStop)
+	// Simulate a cache that will never sync by giving ValidateInitialization
+	// an already-closed stop channel and never starting the factory.
+	closedCh := make(chan struct{})
+	close(closedCh)
+	handler.(*defaultServicePlan).stopCh = closedCh
 
-	instance := newServiceInstance("dummy")
-	instance.Spec.ClusterServiceClassExternalName = "foo"
+	pluginInitializer := scadmission.NewPluginInitializer(fakeClient, f, nil, record.NewFakeRecorder(10))
+	pluginInitializer.Initialize(handler)
 
-	err = handler.(admission.MutationInterface).Admit(admission.NewAttributesRecord(&instance, nil, servicecatalog.Kind("ServiceInstance").WithVersion("version"), instance.Namespace, instance.Name, servicecatalog.Resource("serviceinstances").WithVersion("version"), "", admission.Create, nil))
-	if err == nil {
-		t.Errorf("unexpected success with no ClusterServiceClasses.List succeeding")
-	} else if !strings.Contains(err.Error(), "simulated test failure") {
+	if err := admission.ValidateInitialization(handler); err == nil {
+		t.Errorf("expected an error validating initialization with an unsynced cache")
+	} else if !strings.Contains(err.Error(), "caches failed to sync") {
 		t.Errorf("did not find expected error, got %q", err)
 	}
-	assertPlanReference(t,
-		servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo"},
-		instance.Spec.PlanReference)
 }
 
 func TestWithPlanWorks(t *testing.T) {
@@ -307,28 +253,22 @@ func TestWithPlanWorks(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			var fakeClient *fake.Clientset
-			if tc.namespaced {
-				fakeClient = newFakeServiceCatalogClientForNamespacedTest(nil, newServicePlans(1, false), "" /* do not use get */)
-			} else {
-				fakeClient = newFakeServiceCatalogClientForTest(nil, newClusterServicePlans(1, false), "" /* do not use get */)
-			}
-			handler, informerFactory, err := newHandlerForTest(fakeClient)
+			handler, informerFactory, _, err := newHandlerForTest()
 			if err != nil {
 				t.Errorf("unexpected error initializing handler: %v", err)
 			}
-			informerFactory.Start(wait.NeverStop)
+			if tc.namespaced {
+				addServiceClassAndPlans(informerFactory, nil, newServicePlans(1, false))
+			} else {
+				addClusterServiceClassAndPlans(informerFactory, nil, newClusterServicePlans(1, false))
+			}
 
 			instance := newServiceInstance("dummy")
 			instance.Spec.PlanReference = tc.requestedPlan
 
 			err = handler.(admission.MutationInterface).Admit(admission.NewAttributesRecord(&instance, nil, servicecatalog.Kind("ServiceInstance").WithVersion("version"), instance.Namespace, instance.Name, servicecatalog.Resource("serviceinstances").WithVersion("version"), "", admission.Create, nil))
 			if err != nil {
-				actions := ""
-				for _, action := range fakeClient.Actions() {
-					actions = actions + action.GetVerb() + ":" + action.GetResource().Resource + ":" + action.GetSubresource() + ", "
-				}
-				t.Errorf("unexpected error %q returned from admission handler: %v", err, actions)
+				t.Errorf("unexpected error returned from admission handler: %v", err)
 			}
 			assertPlanReference(t, tc.requestedPlan, instance.Spec.PlanReference)
 		})
@@ -351,17 +291,15 @@ func TestWithNoPlanFailsWithNoClass(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			var fakeClient *fake.Clientset
-			if tc.namespaced {
-				fakeClient = newFakeServiceCatalogClientForNamespacedTest(nil, newServicePlans(1, false), "" /* do not use get */)
-			} else {
-				fakeClient = newFakeServiceCatalogClientForTest(nil, newClusterServicePlans(1, false), "" /* do not use get */)
-			}
-			handler, informerFactory, err := newHandlerForTest(fakeClient)
+			handler, informerFactory, _, err := newHandlerForTest()
 			if err != nil {
 				t.Errorf("unexpected error initializing handler: %v", err)
 			}
-			informerFactory.Start(wait.NeverStop)
+			if tc.namespaced {
+				addServiceClassAndPlans(informerFactory, nil, newServicePlans(1, false))
+			} else {
+				addClusterServiceClassAndPlans(informerFactory, nil, newClusterServicePlans(1, false))
+			}
 
 			instance := newServiceInstance("dummy")
 			instance.Spec.PlanReference = tc.requestedPlan
@@ -388,55 +326,50 @@ func TestWithNoPlanWorksWithSinglePlan(t *testing.T) {
 			servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo"},
 			servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo", ClusterServicePlanExternalName: "bar"}, false},
 		{"cluster external id",
-			servicecatalog.PlanReference{ClusterServiceClassExternalID: "foo"},
-			servicecatalog.PlanReference{ClusterServiceClassExternalID: "foo", ClusterServicePlanExternalID: "12345"}, false},
+			servicecatalog.PlanReference{ClusterServiceClassExternalID: "foo-id"},
+			servicecatalog.PlanReference{ClusterServiceClassExternalID: "foo-id", ClusterServicePlanExternalID: "12345"}, false},
 		{"cluster k8s", servicecatalog.PlanReference{ClusterServiceClassName: "foo-id"},
 			servicecatalog.PlanReference{ClusterServiceClassName: "foo-id", ClusterServicePlanName: "bar-id"}, false},
 		{"ns external name",
 			servicecatalog.PlanReference{ServiceClassExternalName: "foo"},
 			servicecatalog.PlanReference{ServiceClassExternalName: "foo", ServicePlanExternalName: "bar"}, true},
 		{"ns external id",
-			servicecatalog.PlanReference{ServiceClassExternalID: "foo"},
-			servicecatalog.PlanReference{ServiceClassExternalID: "foo", ServicePlanExternalID: "12345"}, true},
+			servicecatalog.PlanReference{ServiceClassExternalID: "foo-id"},
+			servicecatalog.PlanReference{ServiceClassExternalID: "foo-id", ServicePlanExternalID: "12345"}, true},
 		{"ns k8s", servicecatalog.PlanReference{ServiceClassName: "foo-id"},
 			servicecatalog.PlanReference{ServiceClassName: "foo-id", ServicePlanName: "bar-id"}, true},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			var fakeClient *fake.Clientset
+			handler, informerFactory, recorder, err := newHandlerForTest()
+			if err != nil {
+				t.Errorf("unexpected error initializing handler: %v", err)
+			}
+
 			if tc.namespaced {
 				sc := newServiceClass("foo-id", "foo")
 				sps := newServicePlans(1, false)
-				glog.V(4).Infof("Created Service as %+v", sc)
-				fakeClient = newFakeServiceCatalogClientForNamespacedTest(sc, sps, "" /* do not use get */)
+				sps[0].Spec.ServiceClassRef.Name = sc.Name
+				addServiceClassAndPlans(informerFactory, sc, sps)
 			} else {
 				csc := newClusterServiceClass("foo-id", "foo")
 				csps := newClusterServicePlans(1, false)
-				glog.V(4).Infof("Created Service as %+v", csc)
-				fakeClient = newFakeServiceCatalogClientForTest(csc, csps, "" /* do not use get */)
-			}
-
-			handler, informerFactory, err := newHandlerForTest(fakeClient)
-			if err != nil {
-				t.Errorf("unexpected error initializing handler: %v", err)
+				csps[0].Spec.ClusterServiceClassRef.Name = csc.Name
+				addClusterServiceClassAndPlans(informerFactory, csc, csps)
 			}
-			informerFactory.Start(wait.NeverStop)
 
 			instance := newServiceInstance("dummy")
 			instance.Spec.PlanReference = tc.requestedPlan
 
 			err = handler.(admission.MutationInterface).Admit(admission.NewAttributesRecord(&instance, nil, servicecatalog.Kind("ServiceInstance").WithVersion("version"), instance.Namespace, instance.Name, servicecatalog.Resource("serviceinstances").WithVersion("version"), "", admission.Create, nil))
 			if err != nil {
-				actions := ""
-				for _, action := range fakeClient.Actions() {
-					actions = actions + action.GetVerb() + ":" + action.GetResource().Resource + ":" + action.GetSubresource() + ", "
-				}
-				t.Errorf("unexpected error %q returned from admission handler: %v", err, actions)
+				t.Errorf("unexpected error returned from admission handler: %v", err)
 			}
 			assertPlanReference(t,
 				tc.resolvedPlan,
 				instance.Spec.PlanReference)
+			assertDefaultedEvent(t, recorder, "foo (foo-id)", "bar (bar-id)")
 		})
 	}
 }
@@ -449,32 +382,33 @@ func TestWithNoPlanFailsWithMultiplePlans(t *testing.T) {
 		namespaced    bool
 	}{
 		{"cluster external name", servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo"}, false},
-		{"cluster external id", servicecatalog.PlanReference{ClusterServiceClassExternalID: "foo"}, false},
+		{"cluster external id", servicecatalog.PlanReference{ClusterServiceClassExternalID: "foo-id"}, false},
 		{"cluster k8s", servicecatalog.PlanReference{ClusterServiceClassName: "foo-id"}, false},
 		{"ns external name", servicecatalog.PlanReference{ServiceClassExternalName: "foo"}, true},
-		{"ns external id", servicecatalog.PlanReference{ServiceClassExternalID: "foo"}, true},
+		{"ns external id", servicecatalog.PlanReference{ServiceClassExternalID: "foo-id"}, true},
 		{"ns k8s", servicecatalog.PlanReference{ServiceClassName: "foo-id"}, true},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			var fakeClient *fake.Clientset
+			handler, informerFactory, _, err := newHandlerForTest()
+			if err != nil {
+				t.Errorf("unexpected error initializing handler: %v", err)
+			}
+
 			if tc.namespaced {
 				sc := newServiceClass("foo-id", "foo")
 				sps := newServicePlans(2, false)
-				glog.V(4).Infof("Created Service as %+v", sc)
-				fakeClient = newFakeServiceCatalogClientForNamespacedTest(sc, sps, "" /* do not use get */)
+				sps[0].Spec.ServiceClassRef.Name = sc.Name
+				sps[1].Spec.ServiceClassRef.Name = sc.Name
+				addServiceClassAndPlans(informerFactory, sc, sps)
 			} else {
 				csc := newClusterServiceClass("foo-id", "foo")
 				csps := newClusterServicePlans(2, false)
-				glog.V(4).Infof("Created Service as %+v", csc)
-				fakeClient = newFakeServiceCatalogClientForTest(csc, csps, "" /* do not use get */)
-			}
-			handler, informerFactory, err := newHandlerForTest(fakeClient)
-			if err != nil {
-				t.Errorf("unexpected error initializing handler: %v", err)
+				csps[0].Spec.ClusterServiceClassRef.Name = csc.Name
+				csps[1].Spec.ClusterServiceClassRef.Name = csc.Name
+				addClusterServiceClassAndPlans(informerFactory, csc, csps)
 			}
-			informerFactory.Start(wait.NeverStop)
 
 			instance := newServiceInstance("dummy")
 			instance.Spec.PlanReference = tc.requestedPlan
@@ -503,64 +437,386 @@ func TestWithNoPlanSucceedsWithMultiplePlansFromDifferentClasses(t *testing.T) {
 			servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo"},
 			servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo", ClusterServicePlanExternalName: "bar"}, false},
 		{"cluster external id",
-			servicecatalog.PlanReference{ClusterServiceClassExternalID: "foo"},
-			servicecatalog.PlanReference{ClusterServiceClassExternalID: "foo", ClusterServicePlanExternalID: "12345"}, false},
+			servicecatalog.PlanReference{ClusterServiceClassExternalID: "foo-id"},
+			servicecatalog.PlanReference{ClusterServiceClassExternalID: "foo-id", ClusterServicePlanExternalID: "12345"}, false},
 		{"cluster k8s", servicecatalog.PlanReference{ClusterServiceClassName: "foo-id"},
 			servicecatalog.PlanReference{ClusterServiceClassName: "foo-id", ClusterServicePlanName: "bar-id"}, false},
 		{"ns external name",
 			servicecatalog.PlanReference{ServiceClassExternalName: "foo"},
 			servicecatalog.PlanReference{ServiceClassExternalName: "foo", ServicePlanExternalName: "bar"}, true},
 		{"ns external id",
-			servicecatalog.PlanReference{ServiceClassExternalID: "foo"},
-			servicecatalog.PlanReference{ServiceClassExternalID: "foo", ServicePlanExternalID: "12345"}, true},
+			servicecatalog.PlanReference{ServiceClassExternalID: "foo-id"},
+			servicecatalog.PlanReference{ServiceClassExternalID: "foo-id", ServicePlanExternalID: "12345"}, true},
 		{"ns k8s", servicecatalog.PlanReference{ServiceClassName: "foo-id"},
 			servicecatalog.PlanReference{ServiceClassName: "foo-id", ServicePlanName: "bar-id"}, true},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			var fakeClient *fake.Clientset
-			classFilter := "test-serviceclass"
+			handler, informerFactory, recorder, err := newHandlerForTest()
+			if err != nil {
+				t.Errorf("unexpected error initializing handler: %v", err)
+			}
 
+			// newClusterServicePlans/newServicePlans put the second plan on a
+			// different owning class, so only one plan should resolve for
+			// "foo-id" even though the cache holds two plans overall.
 			if tc.namespaced {
 				sc := newServiceClass("foo-id", "foo")
 				sps := newServicePlans(2, true)
-				glog.V(4).Infof("Created Service as %+v", sc)
-				fakeClient = newFakeServiceCatalogClientForNamespacedTest(sc, sps, classFilter /* do not use get */)
+				sps[0].Spec.ServiceClassRef.Name = sc.Name
+				addServiceClassAndPlans(informerFactory, sc, sps)
 			} else {
 				csc := newClusterServiceClass("foo-id", "foo")
 				csps := newClusterServicePlans(2, true)
-				glog.V(4).Infof("Created Service as %+v", csc)
-				fakeClient = newFakeServiceCatalogClientForTest(csc, csps, classFilter /* do not use get */)
+				csps[0].Spec.ClusterServiceClassRef.Name = csc.Name
+				addClusterServiceClassAndPlans(informerFactory, csc, csps)
 			}
 
-			handler, informerFactory, err := newHandlerForTest(fakeClient)
+			instance := newServiceInstance("dummy")
+			instance.Spec.PlanReference = tc.requestedPlan
+
+			err = handler.(admission.MutationInterface).Admit(admission.NewAttributesRecord(&instance, nil, servicecatalog.Kind("ServiceInstance").WithVersion("version"), instance.Namespace, instance.Name, servicecatalog.Resource("serviceinstances").WithVersion("version"), "", admission.Create, nil))
+			if err != nil {
+				t.Errorf("unexpected error returned from admission handler: %v", err)
+			}
+			assertPlanReference(t,
+				tc.resolvedPlan,
+				instance.Spec.PlanReference)
+			assertDefaultedEvent(t, recorder, "foo (foo-id)", "bar (bar-id)")
+		})
+	}
+}
+
+// checks that defaulting fails, rather than silently picking a plan of a class
+// that has been removed from its broker's catalog.
+func TestWithNoPlanFailsWithRemovedClass(t *testing.T) {
+	cases := []struct {
+		name          string
+		requestedPlan servicecatalog.PlanReference
+		namespaced    bool
+	}{
+		{"cluster external name", servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo"}, false},
+		{"cluster k8s", servicecatalog.PlanReference{ClusterServiceClassName: "foo-id"}, false},
+		{"ns external name", servicecatalog.PlanReference{ServiceClassExternalName: "foo"}, true},
+		{"ns k8s", servicecatalog.PlanReference{ServiceClassName: "foo-id"}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, informerFactory, _, err := newHandlerForTest()
 			if err != nil {
 				t.Errorf("unexpected error initializing handler: %v", err)
 			}
-			informerFactory.Start(wait.NeverStop)
+
+			if tc.namespaced {
+				sc := newServiceClass("foo-id", "foo")
+				sc.Status.RemovedFromBrokerCatalog = true
+				addServiceClassAndPlans(informerFactory, sc, newServicePlans(1, false))
+			} else {
+				csc := newClusterServiceClass("foo-id", "foo")
+				csc.Status.RemovedFromBrokerCatalog = true
+				addClusterServiceClassAndPlans(informerFactory, csc, newClusterServicePlans(1, false))
+			}
 
 			instance := newServiceInstance("dummy")
 			instance.Spec.PlanReference = tc.requestedPlan
 
 			err = handler.(admission.MutationInterface).Admit(admission.NewAttributesRecord(&instance, nil, servicecatalog.Kind("ServiceInstance").WithVersion("version"), instance.Namespace, instance.Name, servicecatalog.Resource("serviceinstances").WithVersion("version"), "", admission.Create, nil))
+			if err == nil {
+				t.Errorf("unexpected success defaulting a plan from a class removed from the broker catalog")
+			} else if !strings.Contains(err.Error(), "removed from the broker catalog") && !strings.Contains(err.Error(), "does not exist, can not figure") {
+				t.Errorf("did not find expected error, got %q", err)
+			}
+		})
+	}
+}
+
+// checks that defaulting fails, rather than silently picking a plan that has
+// been removed from its broker's catalog or is being deleted.
+func TestWithNoPlanFailsWithRemovedPlan(t *testing.T) {
+	cases := []struct {
+		name          string
+		requestedPlan servicecatalog.PlanReference
+		namespaced    bool
+	}{
+		{"cluster external name", servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo"}, false},
+		{"ns external name", servicecatalog.PlanReference{ServiceClassExternalName: "foo"}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, informerFactory, _, err := newHandlerForTest()
 			if err != nil {
-				actions := ""
-				for _, action := range fakeClient.Actions() {
-					actions = actions + action.GetVerb() + ":" + action.GetResource().Resource + ":" + action.GetSubresource() + ", "
-				}
-				t.Errorf("unexpected error %q returned from admission handler: %v", err, actions)
+				t.Errorf("unexpected error initializing handler: %v", err)
 			}
-			assertPlanReference(t,
-				tc.resolvedPlan,
-				instance.Spec.PlanReference)
+
+			if tc.namespaced {
+				sc := newServiceClass("foo-id", "foo")
+				sps := newServicePlans(1, false)
+				sps[0].Spec.ServiceClassRef.Name = sc.Name
+				sps[0].Status.RemovedFromBrokerCatalog = true
+				addServiceClassAndPlans(informerFactory, sc, sps)
+			} else {
+				csc := newClusterServiceClass("foo-id", "foo")
+				csps := newClusterServicePlans(1, false)
+				csps[0].Spec.ClusterServiceClassRef.Name = csc.Name
+				csps[0].Status.RemovedFromBrokerCatalog = true
+				addClusterServiceClassAndPlans(informerFactory, csc, csps)
+			}
+
+			instance := newServiceInstance("dummy")
+			instance.Spec.PlanReference = tc.requestedPlan
+
+			err = handler.(admission.MutationInterface).Admit(admission.NewAttributesRecord(&instance, nil, servicecatalog.Kind("ServiceInstance").WithVersion("version"), instance.Namespace, instance.Name, servicecatalog.Resource("serviceinstances").WithVersion("version"), "", admission.Create, nil))
+			if err == nil {
+				t.Errorf("unexpected success defaulting to a plan removed from the broker catalog")
+			} else if !strings.Contains(err.Error(), "no ClusterServicePlans found") && !strings.Contains(err.Error(), "no ServicePlans found") {
+				t.Errorf("did not find expected error, got %q", err)
+			}
+		})
+	}
+}
+
+// checks that defaulting fails against a class or plan that is in the
+// process of being deleted (a non-zero DeletionTimestamp), mirroring
+// TestWithNoPlanFailsWithRemovedClass/TestWithNoPlanFailsWithRemovedPlan but
+// for deleting-instances rather than broker-removed ones.
+func TestWithNoPlanFailsWithDeletingClassOrPlan(t *testing.T) {
+	now := metav1.Now()
+
+	t.Run("deleting class", func(t *testing.T) {
+		handler, informerFactory, _, err := newHandlerForTest()
+		if err != nil {
+			t.Errorf("unexpected error initializing handler: %v", err)
+		}
+
+		csc := newClusterServiceClass("foo-id", "foo")
+		csc.DeletionTimestamp = &now
+		addClusterServiceClassAndPlans(informerFactory, csc, newClusterServicePlans(1, false))
+
+		instance := newServiceInstance("dummy")
+		instance.Spec.PlanReference = servicecatalog.PlanReference{ClusterServiceClassName: "foo-id"}
+
+		err = handler.(admission.MutationInterface).Admit(admission.NewAttributesRecord(&instance, nil, servicecatalog.Kind("ServiceInstance").WithVersion("version"), instance.Namespace, instance.Name, servicecatalog.Resource("serviceinstances").WithVersion("version"), "", admission.Create, nil))
+		if err == nil {
+			t.Errorf("unexpected success defaulting a plan from a class that is being deleted")
+		} else if !strings.Contains(err.Error(), "removed from the broker catalog") {
+			t.Errorf("did not find expected error, got %q", err)
+		}
+	})
+
+	t.Run("deleting plan", func(t *testing.T) {
+		handler, informerFactory, _, err := newHandlerForTest()
+		if err != nil {
+			t.Errorf("unexpected error initializing handler: %v", err)
+		}
+
+		csc := newClusterServiceClass("foo-id", "foo")
+		csps := newClusterServicePlans(1, false)
+		csps[0].Spec.ClusterServiceClassRef.Name = csc.Name
+		csps[0].DeletionTimestamp = &now
+		addClusterServiceClassAndPlans(informerFactory, csc, csps)
+
+		instance := newServiceInstance("dummy")
+		instance.Spec.PlanReference = servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo"}
+
+		err = handler.(admission.MutationInterface).Admit(admission.NewAttributesRecord(&instance, nil, servicecatalog.Kind("ServiceInstance").WithVersion("version"), instance.Namespace, instance.Name, servicecatalog.Resource("serviceinstances").WithVersion("version"), "", admission.Create, nil))
+		if err == nil {
+			t.Errorf("unexpected success defaulting to a plan that is being deleted")
+		} else if !strings.Contains(err.Error(), "no ClusterServicePlans found") {
+			t.Errorf("did not find expected error, got %q", err)
+		}
+	})
+}
+
+// checks that defaulting succeeds, picking out the single plan labeled
+// DefaultServicePlanLabelKey=true, when a class has multiple plans and the
+// instance does not specify a DefaultPlanSelector.
+func TestWithNoPlanResolvesSingleLabeledDefaultPlan(t *testing.T) {
+	cases := []struct {
+		name          string
+		requestedPlan servicecatalog.PlanReference
+		resolvedPlan  servicecatalog.PlanReference
+		namespaced    bool
+	}{
+		{"cluster external name",
+			servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo"},
+			servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo", ClusterServicePlanExternalName: "baz"}, false},
+		{"ns external name",
+			servicecatalog.PlanReference{ServiceClassExternalName: "foo"},
+			servicecatalog.PlanReference{ServiceClassExternalName: "foo", ServicePlanExternalName: "baz"}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, informerFactory, _, err := newHandlerForTest()
+			if err != nil {
+				t.Errorf("unexpected error initializing handler: %v", err)
+			}
+
+			if tc.namespaced {
+				sc := newServiceClass("foo-id", "foo")
+				sps := newServicePlans(2, false)
+				sps[0].Spec.ServiceClassRef.Name = sc.Name
+				sps[1].Spec.ServiceClassRef.Name = sc.Name
+				sps[1].Labels = map[string]string{DefaultServicePlanLabelKey: "true"}
+				addServiceClassAndPlans(informerFactory, sc, sps)
+			} else {
+				csc := newClusterServiceClass("foo-id", "foo")
+				csps := newClusterServicePlans(2, false)
+				csps[0].Spec.ClusterServiceClassRef.Name = csc.Name
+				csps[1].Spec.ClusterServiceClassRef.Name = csc.Name
+				csps[1].Labels = map[string]string{DefaultServicePlanLabelKey: "true"}
+				addClusterServiceClassAndPlans(informerFactory, csc, csps)
+			}
+
+			instance := newServiceInstance("dummy")
+			instance.Spec.PlanReference = tc.requestedPlan
+
+			err = handler.(admission.MutationInterface).Admit(admission.NewAttributesRecord(&instance, nil, servicecatalog.Kind("ServiceInstance").WithVersion("version"), instance.Namespace, instance.Name, servicecatalog.Resource("serviceinstances").WithVersion("version"), "", admission.Create, nil))
+			if err != nil {
+				t.Errorf("unexpected error returned from admission handler: %v", err)
+			}
+			assertPlanReference(t, tc.resolvedPlan, instance.Spec.PlanReference)
 		})
 	}
 }
 
+// checks that defaulting still fails with the original "must be specified"
+// error when a class has multiple plans and none of them is labeled as the
+// default.
+func TestWithNoPlanFailsWithNoLabeledDefaultPlan(t *testing.T) {
+	handler, informerFactory, _, err := newHandlerForTest()
+	if err != nil {
+		t.Errorf("unexpected error initializing handler: %v", err)
+	}
+
+	csc := newClusterServiceClass("foo-id", "foo")
+	csps := newClusterServicePlans(2, false)
+	csps[0].Spec.ClusterServiceClassRef.Name = csc.Name
+	csps[1].Spec.ClusterServiceClassRef.Name = csc.Name
+	addClusterServiceClassAndPlans(informerFactory, csc, csps)
+
+	instance := newServiceInstance("dummy")
+	instance.Spec.PlanReference = servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo"}
+
+	err = handler.(admission.MutationInterface).Admit(admission.NewAttributesRecord(&instance, nil, servicecatalog.Kind("ServiceInstance").WithVersion("version"), instance.Namespace, instance.Name, servicecatalog.Resource("serviceinstances").WithVersion("version"), "", admission.Create, nil))
+	if err == nil {
+		t.Errorf("unexpected success with no plan specified, multiple plans, and none labeled default")
+	} else if !strings.Contains(err.Error(), "has more than one plan, PlanName must be") {
+		t.Errorf("did not find expected error, got %q", err)
+	}
+}
+
+// checks that defaulting fails, naming both candidates, when more than one
+// plan is labeled as the default for the same class.
+func TestWithNoPlanFailsWithMultipleLabeledDefaultPlans(t *testing.T) {
+	handler, informerFactory, _, err := newHandlerForTest()
+	if err != nil {
+		t.Errorf("unexpected error initializing handler: %v", err)
+	}
+
+	csc := newClusterServiceClass("foo-id", "foo")
+	csps := newClusterServicePlans(2, false)
+	csps[0].Spec.ClusterServiceClassRef.Name = csc.Name
+	csps[1].Spec.ClusterServiceClassRef.Name = csc.Name
+	csps[0].Labels = map[string]string{DefaultServicePlanLabelKey: "true"}
+	csps[1].Labels = map[string]string{DefaultServicePlanLabelKey: "true"}
+	addClusterServiceClassAndPlans(informerFactory, csc, csps)
+
+	instance := newServiceInstance("dummy")
+	instance.Spec.PlanReference = servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo"}
+
+	err = handler.(admission.MutationInterface).Admit(admission.NewAttributesRecord(&instance, nil, servicecatalog.Kind("ServiceInstance").WithVersion("version"), instance.Namespace, instance.Name, servicecatalog.Resource("serviceinstances").WithVersion("version"), "", admission.Create, nil))
+	if err == nil {
+		t.Errorf("unexpected success with two plans labeled default for the same class")
+	} else if !strings.Contains(err.Error(), "more than one plan labeled") {
+		t.Errorf("did not find expected error, got %q", err)
+	} else if !strings.Contains(err.Error(), "bar (bar-id)") || !strings.Contains(err.Error(), "baz (baz-id)") {
+		t.Errorf("expected error to name both candidates, got %q", err)
+	}
+}
+
+// checks that a user-supplied DefaultPlanSelector narrows a multi-plan class
+// down to exactly one match, taking priority over the
+// DefaultServicePlanLabelKey label.
+func TestWithNoPlanResolvesViaDefaultPlanSelector(t *testing.T) {
+	handler, informerFactory, _, err := newHandlerForTest()
+	if err != nil {
+		t.Errorf("unexpected error initializing handler: %v", err)
+	}
+
+	csc := newClusterServiceClass("foo-id", "foo")
+	csps := newClusterServicePlans(2, false)
+	csps[0].Spec.ClusterServiceClassRef.Name = csc.Name
+	csps[1].Spec.ClusterServiceClassRef.Name = csc.Name
+	csps[0].Labels = map[string]string{DefaultServicePlanLabelKey: "true"}
+	csps[1].Labels = map[string]string{"tier": "premium"}
+	addClusterServiceClassAndPlans(informerFactory, csc, csps)
+
+	instance := newServiceInstance("dummy")
+	instance.Spec.PlanReference = servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo"}
+	instance.Spec.DefaultPlanSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "premium"}}
+
+	err = handler.(admission.MutationInterface).Admit(admission.NewAttributesRecord(&instance, nil, servicecatalog.Kind("ServiceInstance").WithVersion("version"), instance.Namespace, instance.Name, servicecatalog.Resource("serviceinstances").WithVersion("version"), "", admission.Create, nil))
+	if err != nil {
+		t.Errorf("unexpected error returned from admission handler: %v", err)
+	}
+	assertPlanReference(t,
+		servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo", ClusterServicePlanExternalName: "baz"},
+		instance.Spec.PlanReference)
+}
+
+// checks that defaulting fails, naming the candidates, when a
+// DefaultPlanSelector matches more than one plan.
+func TestWithNoPlanFailsWithAmbiguousDefaultPlanSelector(t *testing.T) {
+	handler, informerFactory, _, err := newHandlerForTest()
+	if err != nil {
+		t.Errorf("unexpected error initializing handler: %v", err)
+	}
+
+	csc := newClusterServiceClass("foo-id", "foo")
+	csps := newClusterServicePlans(2, false)
+	csps[0].Spec.ClusterServiceClassRef.Name = csc.Name
+	csps[1].Spec.ClusterServiceClassRef.Name = csc.Name
+	csps[0].Labels = map[string]string{"tier": "premium"}
+	csps[1].Labels = map[string]string{"tier": "premium"}
+	addClusterServiceClassAndPlans(informerFactory, csc, csps)
+
+	instance := newServiceInstance("dummy")
+	instance.Spec.PlanReference = servicecatalog.PlanReference{ClusterServiceClassExternalName: "foo"}
+	instance.Spec.DefaultPlanSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "premium"}}
+
+	err = handler.(admission.MutationInterface).Admit(admission.NewAttributesRecord(&instance, nil, servicecatalog.Kind("ServiceInstance").WithVersion("version"), instance.Namespace, instance.Name, servicecatalog.Resource("serviceinstances").WithVersion("version"), "", admission.Create, nil))
+	if err == nil {
+		t.Errorf("unexpected success with a DefaultPlanSelector matching two plans")
+	} else if !strings.Contains(err.Error(), "DefaultPlanSelector matched more than one plan") {
+		t.Errorf("did not find expected error, got %q", err)
+	}
+}
+
 // Compares expected and actual PlanReferences and reports with Errorf of any mismatch
 func assertPlanReference(t *testing.T, expected servicecatalog.PlanReference, actual servicecatalog.PlanReference) {
 	if expected != actual {
 		t.Errorf("PlanReference was not as expected: %+v actual: %+v", expected, actual)
 	}
 }
+
+// assertDefaultedEvent checks that recorder captured exactly the Normal
+// DefaultedServicePlan event that Admit emits when it auto-selects a plan,
+// naming prettyClass and prettyPlan.
+func assertDefaultedEvent(t *testing.T, recorder *record.FakeRecorder, prettyClass, prettyPlan string) {
+	t.Helper()
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, corev1.EventTypeNormal) || !strings.Contains(event, DefaultedServicePlanEventReason) {
+			t.Errorf("event was not a Normal DefaultedServicePlan event: %q", event)
+		}
+		if !strings.Contains(event, prettyClass) || !strings.Contains(event, prettyPlan) {
+			t.Errorf("event %q did not name class %q and plan %q", event, prettyClass, prettyPlan)
+		}
+	default:
+		t.Errorf("expected a DefaultedServicePlan event to be recorded, got none")
+	}
+}